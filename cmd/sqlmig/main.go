@@ -0,0 +1,45 @@
+// Command sqlmig provides a small CLI around sqlmig.Source's helpers, for
+// scaffolding migration files from the shell.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/daaku/sqlmig"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	switch os.Args[1] {
+	case "new":
+		newCmd(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: sqlmig new [-dir dir] name")
+}
+
+func newCmd(args []string) {
+	fset := flag.NewFlagSet("new", flag.ExitOnError)
+	dir := fset.String("dir", ".", "directory to write the migration into")
+	fset.Parse(args)
+	if fset.NArg() != 1 {
+		usage()
+		os.Exit(2)
+	}
+	path, err := sqlmig.Source{}.NewMigration(fset.Arg(0), *dir)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(path)
+}
@@ -0,0 +1,371 @@
+package sqlmig
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dialect supplies the SQL-dialect-specific pieces Migrate needs: parameter
+// placeholders, identifier quoting, bookkeeping table DDL, and an
+// advisory-lock primitive for cross-process coordination. Source defaults to
+// SQLite when Dialect is nil, matching sqlmig's original SQLite-only
+// behavior. Table arguments are always the bare, unquoted table name; each
+// method quotes it as needed for the statement it builds.
+type Dialect interface {
+	// Placeholder returns the parameter placeholder for the i'th (1-indexed)
+	// bind argument in a statement, e.g. "?" for SQLite/MySQL or "$1" for
+	// Postgres.
+	Placeholder(i int) string
+	// QuoteIdent quotes name as a SQL identifier.
+	QuoteIdent(name string) string
+	// CreateTableSQL returns the DDL to create the bookkeeping table, with
+	// all of its columns, if it doesn't already exist.
+	CreateTableSQL(table string) string
+	// HasColumn reports whether table already has column.
+	HasColumn(ctx context.Context, db DB, table, column string) (bool, error)
+	// AddColumnSQL returns the DDL to add column, of type ddl, to an existing
+	// table. ddl is used verbatim, so it must already be this dialect's type
+	// name (as returned by ColumnType).
+	AddColumnSQL(table, column, ddl string) string
+	// ColumnType returns this dialect's name for a generic sqlmig column
+	// type, one of "timestamp" or "text", used when adding columns to a
+	// bookkeeping table that predates them.
+	ColumnType(generic string) string
+	// AdvisoryLock acquires a database-level advisory lock scoped to name,
+	// waiting up to timeout (or indefinitely, if timeout is zero). It
+	// returns a function that releases the lock. Migrate calls it with a db
+	// pinned to a single connection whenever the caller's DB supports that
+	// (see Source.Migrate), since the Postgres/MySQL/MSSQL locks below are
+	// scoped to the connection that takes them, not to the database as a
+	// whole.
+	AdvisoryLock(ctx context.Context, db DB, name string, timeout time.Duration) (unlock func(context.Context) error, err error)
+}
+
+// SQLite is the Dialect for github.com/mattn/go-sqlite3 and compatible
+// drivers.
+type SQLite struct{}
+
+func (SQLite) Placeholder(int) string { return "?" }
+
+func (SQLite) QuoteIdent(name string) string { return `"` + name + `"` }
+
+func (d SQLite) CreateTableSQL(table string) string {
+	return fmt.Sprintf(`
+	create table if not exists %s (
+		name text primary key,
+		applied_at timestamp,
+		down_sql text,
+		checksum text
+	)`, d.QuoteIdent(table))
+}
+
+// HasColumn reads the table's schema back with `pragma table_info`, since
+// SQLite has no information_schema.
+func (d SQLite) HasColumn(ctx context.Context, db DB, table, column string) (bool, error) {
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`pragma table_info(%s)`, d.QuoteIdent(table)))
+	if err != nil {
+		return false, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var cid, notnull, pk int
+		var name, ctype string
+		var dflt sql.NullString
+		if err := rows.Scan(&cid, &name, &ctype, &notnull, &dflt, &pk); err != nil {
+			return false, err
+		}
+		if name == column {
+			return true, nil
+		}
+	}
+	return false, rows.Err()
+}
+
+func (d SQLite) AddColumnSQL(table, column, ddl string) string {
+	return fmt.Sprintf(`alter table %s add column %s %s`, d.QuoteIdent(table), column, ddl)
+}
+
+func (SQLite) ColumnType(generic string) string { return generic }
+
+// lockLease bounds how long a SQLite advisory lock may go without a
+// heartbeat before another Migrate is allowed to treat its holder as dead
+// and steal it. It must comfortably exceed lockHeartbeat. A var, not a
+// const, so tests can shrink it instead of waiting out the real interval.
+var lockLease = 30 * time.Second
+
+// lockHeartbeat is how often a held SQLite advisory lock refreshes its
+// locked_at timestamp, keeping it well inside lockLease for as long as
+// Migrate is actually still running.
+var lockHeartbeat = lockLease / 6
+
+// AdvisoryLock has no true advisory lock primitive to call on SQLite, so it
+// takes a compare-and-swap "locked" flag on a dedicated row instead, polling
+// until it wins the swap, ctx is done, or timeout elapses. Each poll is its
+// own statement, auto-committed on its own connection, so the lock never
+// holds a transaction (and therefore a pooled connection) open for the
+// caller's use of db outside of AdvisoryLock itself. A lock is also won if
+// it's held but hasn't been refreshed in over lockLease, so a process killed
+// while holding it doesn't deadlock every future Migrate; the returned
+// unlock stops refreshing and releases the row once called.
+func (SQLite) AdvisoryLock(ctx context.Context, db DB, name string, timeout time.Duration) (func(context.Context) error, error) {
+	if _, err := db.ExecContext(ctx, `create table if not exists db_migrations_lock (name text primary key, locked int not null default 0, locked_at timestamp)`); err != nil {
+		return nil, fmt.Errorf("sqlmig: error creating db_migrations_lock table: %w", err)
+	}
+	if _, err := db.ExecContext(ctx, `insert or ignore into db_migrations_lock (name, locked) values (?, 0)`, name); err != nil {
+		return nil, fmt.Errorf("sqlmig: error seeding db_migrations_lock: %w", err)
+	}
+
+	var deadline time.Time
+	if timeout > 0 {
+		deadline = time.Now().Add(timeout)
+	}
+	const pollInterval = 25 * time.Millisecond
+	for {
+		now := time.Now().UTC()
+		res, err := db.ExecContext(ctx,
+			`update db_migrations_lock set locked = 1, locked_at = ? where name = ? and (locked = 0 or locked_at < ?)`,
+			now, name, now.Add(-lockLease))
+		if err != nil {
+			return nil, fmt.Errorf("sqlmig: error acquiring advisory lock: %w", err)
+		}
+		if n, err := res.RowsAffected(); err == nil && n == 1 {
+			break
+		}
+		if !deadline.IsZero() && time.Now().After(deadline) {
+			return nil, ErrLockTimeout
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+	}
+
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(lockHeartbeat)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				db.ExecContext(context.Background(), `update db_migrations_lock set locked_at = ? where name = ?`, time.Now().UTC(), name)
+			}
+		}
+	}()
+
+	return func(ctx context.Context) error {
+		close(stop)
+		<-stopped
+		_, err := db.ExecContext(ctx, `update db_migrations_lock set locked = 0 where name = ?`, name)
+		return err
+	}, nil
+}
+
+// Postgres is the Dialect for github.com/lib/pq, github.com/jackc/pgx, and
+// compatible drivers.
+type Postgres struct{}
+
+func (Postgres) Placeholder(i int) string { return "$" + strconv.Itoa(i) }
+
+func (Postgres) QuoteIdent(name string) string { return `"` + name + `"` }
+
+func (d Postgres) CreateTableSQL(table string) string {
+	return fmt.Sprintf(`
+	create table if not exists %s (
+		name text primary key,
+		applied_at timestamptz,
+		down_sql text,
+		checksum text
+	)`, d.QuoteIdent(table))
+}
+
+func (Postgres) HasColumn(ctx context.Context, db DB, table, column string) (bool, error) {
+	var exists bool
+	const q = `select exists (select 1 from information_schema.columns where table_name = $1 and column_name = $2)`
+	if err := db.QueryRowContext(ctx, q, table, column).Scan(&exists); err != nil {
+		return false, err
+	}
+	return exists, nil
+}
+
+func (d Postgres) AddColumnSQL(table, column, ddl string) string {
+	return fmt.Sprintf(`alter table %s add column %s %s`, d.QuoteIdent(table), column, ddl)
+}
+
+func (Postgres) ColumnType(generic string) string {
+	if generic == "timestamp" {
+		return "timestamptz"
+	}
+	return generic
+}
+
+// AdvisoryLock uses pg_advisory_lock, scoped by hashing name down to the int
+// it takes, bounded by a session-level lock_timeout when timeout is set.
+// pg_advisory_lock/pg_advisory_unlock are session-scoped: db must be pinned
+// to the same connection for the lock, the migration work it guards, and the
+// eventual unlock, or the unlock becomes a no-op that leaks the lock on a
+// connection Migrate no longer has a reference to.
+func (Postgres) AdvisoryLock(ctx context.Context, db DB, name string, timeout time.Duration) (func(context.Context) error, error) {
+	if timeout > 0 {
+		setSQL := fmt.Sprintf(`set lock_timeout = '%dms'`, timeout.Milliseconds())
+		if _, err := db.ExecContext(ctx, setSQL); err != nil {
+			return nil, fmt.Errorf("sqlmig: error setting lock_timeout: %w", err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, `select pg_advisory_lock(hashtext($1))`, name); err != nil {
+		if strings.Contains(strings.ToLower(err.Error()), "lock timeout") {
+			return nil, ErrLockTimeout
+		}
+		return nil, fmt.Errorf("sqlmig: error acquiring advisory lock: %w", err)
+	}
+	return func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, `select pg_advisory_unlock(hashtext($1))`, name)
+		return err
+	}, nil
+}
+
+// MySQL is the Dialect for github.com/go-sql-driver/mysql and compatible
+// drivers.
+type MySQL struct{}
+
+func (MySQL) Placeholder(int) string { return "?" }
+
+func (MySQL) QuoteIdent(name string) string { return "`" + name + "`" }
+
+func (d MySQL) CreateTableSQL(table string) string {
+	return fmt.Sprintf(`
+	create table if not exists %s (
+		name varchar(255) primary key,
+		applied_at datetime,
+		down_sql text,
+		checksum varchar(64)
+	)`, d.QuoteIdent(table))
+}
+
+func (MySQL) HasColumn(ctx context.Context, db DB, table, column string) (bool, error) {
+	var n int
+	const q = `select count(*) from information_schema.columns where table_schema = database() and table_name = ? and column_name = ?`
+	if err := db.QueryRowContext(ctx, q, table, column).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func (d MySQL) AddColumnSQL(table, column, ddl string) string {
+	return fmt.Sprintf(`alter table %s add column %s %s`, d.QuoteIdent(table), column, ddl)
+}
+
+func (MySQL) ColumnType(generic string) string {
+	if generic == "timestamp" {
+		return "datetime"
+	}
+	return generic
+}
+
+// AdvisoryLock uses GET_LOCK/RELEASE_LOCK, scoped by name directly since
+// MySQL's lock names are arbitrary strings. Like Postgres's, this lock is
+// connection-scoped: db must stay pinned to the same connection from
+// acquisition through release, or RELEASE_LOCK silently does nothing and
+// the lock leaks on a connection Migrate has since let go of.
+func (MySQL) AdvisoryLock(ctx context.Context, db DB, name string, timeout time.Duration) (func(context.Context) error, error) {
+	seconds := -1
+	if timeout > 0 {
+		seconds = int(timeout.Seconds())
+		if seconds == 0 {
+			seconds = 1
+		}
+	}
+	var got sql.NullInt64
+	if err := db.QueryRowContext(ctx, `select get_lock(?, ?)`, name, seconds).Scan(&got); err != nil {
+		return nil, fmt.Errorf("sqlmig: error acquiring advisory lock: %w", err)
+	}
+	if !got.Valid || got.Int64 != 1 {
+		return nil, ErrLockTimeout
+	}
+	return func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, `select release_lock(?)`, name)
+		return err
+	}, nil
+}
+
+// MSSQL is the Dialect for github.com/microsoft/go-mssqldb and compatible
+// drivers.
+type MSSQL struct{}
+
+func (MSSQL) Placeholder(i int) string { return "@p" + strconv.Itoa(i) }
+
+func (MSSQL) QuoteIdent(name string) string { return "[" + name + "]" }
+
+// CreateTableSQL guards the create with a sysobjects lookup on the bare,
+// unquoted table name, since sysobjects.name never includes the brackets
+// QuoteIdent adds to the create statement itself.
+func (d MSSQL) CreateTableSQL(table string) string {
+	return fmt.Sprintf(`
+	if not exists (select * from sysobjects where name = '%s' and xtype = 'U')
+	create table %s (
+		name nvarchar(255) primary key,
+		applied_at datetime2,
+		down_sql nvarchar(max),
+		checksum varchar(64)
+	)`, table, d.QuoteIdent(table))
+}
+
+func (MSSQL) HasColumn(ctx context.Context, db DB, table, column string) (bool, error) {
+	var n int
+	const q = `select count(*) from information_schema.columns where table_name = @p1 and column_name = @p2`
+	if err := db.QueryRowContext(ctx, q, table, column).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// AddColumnSQL omits the "column" keyword MSSQL doesn't accept: it's
+// `alter table t add c type`, not `add column c type`.
+func (d MSSQL) AddColumnSQL(table, column, ddl string) string {
+	return fmt.Sprintf(`alter table %s add %s %s`, d.QuoteIdent(table), column, ddl)
+}
+
+func (MSSQL) ColumnType(generic string) string {
+	switch generic {
+	case "timestamp":
+		return "datetime2"
+	case "text":
+		return "nvarchar(max)"
+	}
+	return generic
+}
+
+// AdvisoryLock uses sp_getapplock/sp_releaseapplock, the closest MSSQL
+// equivalent of Postgres and MySQL's advisory locks. Both calls pass
+// @LockOwner = 'Session', so the lock is session-scoped: db must stay pinned
+// to the same connection for the lock, the migration work it guards, and
+// sp_releaseapplock, or the release call runs on a different session than
+// the one holding the lock and does nothing.
+func (MSSQL) AdvisoryLock(ctx context.Context, db DB, name string, timeout time.Duration) (func(context.Context) error, error) {
+	ms := -1
+	if timeout > 0 {
+		ms = int(timeout.Milliseconds())
+	}
+	const lockSQL = `declare @res int
+		exec @res = sp_getapplock @Resource = @p1, @LockMode = 'Exclusive', @LockOwner = 'Session', @LockTimeout = @p2
+		select @res`
+	var result int
+	if err := db.QueryRowContext(ctx, lockSQL, name, ms).Scan(&result); err != nil {
+		return nil, fmt.Errorf("sqlmig: error acquiring advisory lock: %w", err)
+	}
+	if result < 0 {
+		return nil, ErrLockTimeout
+	}
+	return func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, `exec sp_releaseapplock @Resource = @p1, @LockOwner = 'Session'`, name)
+		return err
+	}, nil
+}
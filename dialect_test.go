@@ -0,0 +1,101 @@
+package sqlmig
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDialectPlaceholder(t *testing.T) {
+	cases := []struct {
+		d    Dialect
+		i    int
+		want string
+	}{
+		{SQLite{}, 1, "?"},
+		{SQLite{}, 2, "?"},
+		{MySQL{}, 3, "?"},
+		{Postgres{}, 1, "$1"},
+		{Postgres{}, 3, "$3"},
+		{MSSQL{}, 1, "@p1"},
+		{MSSQL{}, 2, "@p2"},
+	}
+	for _, c := range cases {
+		if got := c.d.Placeholder(c.i); got != c.want {
+			t.Errorf("%T.Placeholder(%d) = %q, want %q", c.d, c.i, got, c.want)
+		}
+	}
+}
+
+func TestDialectQuoteIdent(t *testing.T) {
+	cases := []struct {
+		d    Dialect
+		want string
+	}{
+		{SQLite{}, `"db_migrations"`},
+		{Postgres{}, `"db_migrations"`},
+		{MySQL{}, "`db_migrations`"},
+		{MSSQL{}, "[db_migrations]"},
+	}
+	for _, c := range cases {
+		if got := c.d.QuoteIdent("db_migrations"); got != c.want {
+			t.Errorf("%T.QuoteIdent() = %q, want %q", c.d, got, c.want)
+		}
+	}
+}
+
+func TestDialectColumnType(t *testing.T) {
+	cases := []struct {
+		d       Dialect
+		generic string
+		want    string
+	}{
+		{SQLite{}, "timestamp", "timestamp"},
+		{SQLite{}, "text", "text"},
+		{Postgres{}, "timestamp", "timestamptz"},
+		{Postgres{}, "text", "text"},
+		{MySQL{}, "timestamp", "datetime"},
+		{MySQL{}, "text", "text"},
+		{MSSQL{}, "timestamp", "datetime2"},
+		{MSSQL{}, "text", "nvarchar(max)"},
+	}
+	for _, c := range cases {
+		if got := c.d.ColumnType(c.generic); got != c.want {
+			t.Errorf("%T.ColumnType(%q) = %q, want %q", c.d, c.generic, got, c.want)
+		}
+	}
+}
+
+// TestMSSQLCreateTableSQLChecksRawName guards the bug where the sysobjects
+// existence check was compared against an already-quoted table name and
+// never matched, causing every run after the first to re-attempt create
+// table.
+func TestMSSQLCreateTableSQLChecksRawName(t *testing.T) {
+	got := MSSQL{}.CreateTableSQL("db_migrations")
+	if !strings.Contains(got, `name = 'db_migrations'`) {
+		t.Errorf("CreateTableSQL existence check doesn't use the raw table name: %s", got)
+	}
+	if !strings.Contains(got, "create table [db_migrations]") {
+		t.Errorf("CreateTableSQL doesn't quote the table name in the create statement: %s", got)
+	}
+}
+
+// TestMSSQLAddColumnSQLOmitsColumnKeyword guards the bug where ensureColumn
+// emitted invalid MSSQL syntax by always using "add column", which MSSQL
+// doesn't accept.
+func TestMSSQLAddColumnSQLOmitsColumnKeyword(t *testing.T) {
+	got := MSSQL{}.AddColumnSQL("db_migrations", "checksum", "varchar(64)")
+	want := "alter table [db_migrations] add checksum varchar(64)"
+	if got != want {
+		t.Errorf("AddColumnSQL() = %q, want %q", got, want)
+	}
+}
+
+func TestDialectAddColumnSQLUsesColumnKeyword(t *testing.T) {
+	cases := []Dialect{SQLite{}, Postgres{}, MySQL{}}
+	for _, d := range cases {
+		got := d.AddColumnSQL("db_migrations", "checksum", "text")
+		if !strings.Contains(got, "add column checksum") {
+			t.Errorf("%T.AddColumnSQL() = %q, want it to contain \"add column checksum\"", d, got)
+		}
+	}
+}
@@ -0,0 +1,338 @@
+package sqlmig
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeStore is the shared, in-memory backing for a fake *sql.DB, modeling
+// just enough of the db_migrations and db_migrations_lock tables (and an
+// optional connection-scoped advisory lock) to drive Source/Migrator
+// against something that behaves like a real database/sql driver, without
+// vendoring one.
+type fakeStore struct {
+	mu  sync.Mutex
+	mig map[string]*fakeMigRow
+
+	lockedBy int // 0 means unlocked, 1 means locked; the owner isn't tracked
+	lockedAt time.Time
+
+	connLockMu    sync.Mutex
+	connLockCond  *sync.Cond
+	connLockOwner *fakeConn
+}
+
+type fakeMigRow struct {
+	name      string
+	appliedAt time.Time
+	downSQL   string
+	checksum  string
+}
+
+func newFakeStore() *fakeStore {
+	s := &fakeStore{mig: map[string]*fakeMigRow{}}
+	s.connLockCond = sync.NewCond(&s.connLockMu)
+	return s
+}
+
+var (
+	fakeStoresMu sync.Mutex
+	fakeStores   = map[string]*fakeStore{}
+	fakeConnSeq  int
+)
+
+func init() {
+	sql.Register("sqlmigfake", fakeDriver{})
+}
+
+// newFakeDB registers a fresh fakeStore under a unique DSN and returns a
+// *sql.DB backed by it, cleaned up when t ends.
+func newFakeDB(t *testing.T) *sql.DB {
+	t.Helper()
+	name := t.Name()
+	fakeStoresMu.Lock()
+	fakeStores[name] = newFakeStore()
+	fakeStoresMu.Unlock()
+	db, err := sql.Open("sqlmigfake", name)
+	if err != nil {
+		t.Fatalf("sql.Open: %v", err)
+	}
+	t.Cleanup(func() {
+		db.Close()
+		fakeStoresMu.Lock()
+		delete(fakeStores, name)
+		fakeStoresMu.Unlock()
+	})
+	return db
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(name string) (driver.Conn, error) {
+	fakeStoresMu.Lock()
+	store := fakeStores[name]
+	fakeConnSeq++
+	id := fakeConnSeq
+	fakeStoresMu.Unlock()
+	if store == nil {
+		return nil, fmt.Errorf("fakedb: unknown dsn %q", name)
+	}
+	return &fakeConn{id: id, store: store}, nil
+}
+
+// fakeConn is a single connection onto a shared fakeStore. Its identity
+// (not its state) is what makes the connection-scoped advisory lock in
+// testConnDialect behave like Postgres's pg_advisory_lock: only the fakeConn
+// that acquired it can release it.
+type fakeConn struct {
+	id    int
+	store *fakeStore
+	tx    *fakeSnapshot
+}
+
+type fakeSnapshot struct {
+	mig      map[string]*fakeMigRow
+	lockedBy int
+	lockedAt time.Time
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, fmt.Errorf("fakedb: Prepare unsupported, want ExecContext/QueryContext: %s", query)
+}
+
+func (c *fakeConn) Close() error { return nil }
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *fakeConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	snap := &fakeSnapshot{mig: make(map[string]*fakeMigRow, len(c.store.mig)), lockedBy: c.store.lockedBy, lockedAt: c.store.lockedAt}
+	for k, v := range c.store.mig {
+		cp := *v
+		snap.mig[k] = &cp
+	}
+	c.tx = snap
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.tx = nil
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	if c.tx == nil {
+		return nil
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	c.store.mig = c.tx.mig
+	c.store.lockedBy = c.tx.lockedBy
+	c.store.lockedAt = c.tx.lockedAt
+	c.tx = nil
+	return nil
+}
+
+func normalizeSQL(query string) string {
+	return strings.Join(strings.Fields(strings.ToLower(query)), " ")
+}
+
+func namedValuesToArgs(nv []driver.NamedValue) []driver.Value {
+	args := make([]driver.Value, len(nv))
+	for i, v := range nv {
+		args[i] = v.Value
+	}
+	return args
+}
+
+// ExecContext dispatches on the (normalized) SQL text of the small, fixed
+// set of statements sqlmig itself ever issues, plus an escape hatch for the
+// two fake_conn_lock/fake_conn_unlock pseudo-statements testConnDialect uses
+// to model a connection-scoped advisory lock. Anything else is treated as
+// an opaque migration statement and succeeds unless it contains "force
+// fail", so tests can write migrations as arbitrary SQL.
+func (c *fakeConn) ExecContext(ctx context.Context, query string, nv []driver.NamedValue) (driver.Result, error) {
+	norm := normalizeSQL(query)
+	switch {
+	case strings.HasPrefix(norm, "select fake_conn_lock"):
+		return c.store.acquireConnLock(c)
+	case strings.HasPrefix(norm, "select fake_conn_unlock"):
+		return c.store.releaseConnLock(c)
+	}
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	return execLocked(c.store, norm, namedValuesToArgs(nv))
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, nv []driver.NamedValue) (driver.Rows, error) {
+	norm := normalizeSQL(query)
+	c.store.mu.Lock()
+	defer c.store.mu.Unlock()
+	return queryLocked(c.store, norm, namedValuesToArgs(nv))
+}
+
+// acquireConnLock and releaseConnLock use their own lock, not fakeStore.mu,
+// since acquireConnLock blocks (as pg_advisory_lock does) and must not hold
+// the store's main mutex while waiting -- every other connection's
+// ExecContext/QueryContext needs that mutex to make progress, including the
+// one that will eventually release this lock.
+func (s *fakeStore) acquireConnLock(c *fakeConn) (driver.Result, error) {
+	s.connLockMu.Lock()
+	defer s.connLockMu.Unlock()
+	for s.connLockOwner != nil && s.connLockOwner != c {
+		s.connLockCond.Wait()
+	}
+	s.connLockOwner = c
+	return fakeResult{}, nil
+}
+
+func (s *fakeStore) releaseConnLock(c *fakeConn) (driver.Result, error) {
+	s.connLockMu.Lock()
+	defer s.connLockMu.Unlock()
+	if s.connLockOwner == c {
+		s.connLockOwner = nil
+		s.connLockCond.Broadcast()
+	}
+	// A release from a non-owning connection (or with nobody holding it) is
+	// a silent no-op, exactly like pg_advisory_unlock/RELEASE_LOCK.
+	return fakeResult{}, nil
+}
+
+func execLocked(s *fakeStore, norm string, args []driver.Value) (driver.Result, error) {
+	switch {
+	case strings.HasPrefix(norm, "create table if not exists"):
+		return fakeResult{}, nil
+	case strings.HasPrefix(norm, "insert or ignore into") && strings.Contains(norm, "db_migrations_lock"):
+		return fakeResult{rows: 1}, nil
+	case strings.HasPrefix(norm, "insert into") && strings.Contains(norm, "db_migrations_lock"):
+		// Test-only seeding of a pre-existing lock row, e.g. to simulate one
+		// left behind by a process that crashed before it could unlock.
+		s.lockedBy = 1
+		s.lockedAt = args[1].(time.Time)
+		return fakeResult{rows: 1}, nil
+	case strings.HasPrefix(norm, "update") && strings.Contains(norm, "db_migrations_lock") && strings.Contains(norm, "locked = 1"):
+		now := args[0].(time.Time)
+		staleBefore := args[2].(time.Time)
+		if s.lockedBy == 0 || s.lockedAt.Before(staleBefore) {
+			s.lockedBy = 1
+			s.lockedAt = now
+			return fakeResult{rows: 1}, nil
+		}
+		return fakeResult{rows: 0}, nil
+	case strings.HasPrefix(norm, "update") && strings.Contains(norm, "db_migrations_lock") && strings.Contains(norm, "locked_at = ?"):
+		if s.lockedBy != 0 {
+			s.lockedAt = args[0].(time.Time)
+		}
+		return fakeResult{rows: 1}, nil
+	case strings.HasPrefix(norm, "update") && strings.Contains(norm, "db_migrations_lock") && strings.Contains(norm, "locked = 0"):
+		s.lockedBy = 0
+		return fakeResult{rows: 1}, nil
+	case strings.HasPrefix(norm, "insert into") && strings.Contains(norm, "applied_at"):
+		name := args[0].(string)
+		if _, exists := s.mig[name]; exists {
+			return nil, fmt.Errorf("fakedb: UNIQUE constraint failed: db_migrations.name (%s)", name)
+		}
+		s.mig[name] = &fakeMigRow{name: name, appliedAt: args[1].(time.Time), downSQL: args[2].(string), checksum: args[3].(string)}
+		return fakeResult{rows: 1}, nil
+	case strings.HasPrefix(norm, "update") && strings.Contains(norm, "set checksum"):
+		if row, ok := s.mig[args[1].(string)]; ok {
+			row.checksum = args[0].(string)
+		}
+		return fakeResult{rows: 1}, nil
+	case strings.HasPrefix(norm, "delete from"):
+		delete(s.mig, args[0].(string))
+		return fakeResult{rows: 1}, nil
+	case strings.HasPrefix(norm, "alter table"):
+		return fakeResult{}, nil
+	default:
+		if strings.Contains(norm, "force fail") {
+			return nil, fmt.Errorf("fakedb: forced failure executing: %s", norm)
+		}
+		return fakeResult{}, nil
+	}
+}
+
+func queryLocked(s *fakeStore, norm string, args []driver.Value) (driver.Rows, error) {
+	switch {
+	case strings.HasPrefix(norm, "pragma table_info"):
+		cols := []string{"cid", "name", "type", "notnull", "dflt_value", "pk"}
+		rows := [][]driver.Value{
+			{int64(0), "name", "text", int64(0), nil, int64(1)},
+			{int64(1), "applied_at", "timestamp", int64(0), nil, int64(0)},
+			{int64(2), "down_sql", "text", int64(0), nil, int64(0)},
+			{int64(3), "checksum", "text", int64(0), nil, int64(0)},
+		}
+		return &fakeRows{cols: cols, rows: rows}, nil
+	case strings.HasPrefix(norm, "select count(*)"):
+		n := int64(0)
+		if _, ok := s.mig[args[0].(string)]; ok {
+			n = 1
+		}
+		return &fakeRows{cols: []string{"count(*)"}, rows: [][]driver.Value{{n}}}, nil
+	case strings.HasPrefix(norm, "select name, checksum"):
+		var rows [][]driver.Value
+		for _, r := range s.mig {
+			rows = append(rows, []driver.Value{r.name, r.checksum})
+		}
+		return &fakeRows{cols: []string{"name", "checksum"}, rows: rows}, nil
+	case strings.HasPrefix(norm, "select name, applied_at"):
+		var rows [][]driver.Value
+		for _, r := range s.mig {
+			rows = append(rows, []driver.Value{r.name, r.appliedAt})
+		}
+		return &fakeRows{cols: []string{"name", "applied_at"}, rows: rows}, nil
+	case strings.HasPrefix(norm, "select name, down_sql"):
+		names := make([]string, 0, len(s.mig))
+		for n := range s.mig {
+			names = append(names, n)
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(names)))
+		var rows [][]driver.Value
+		for _, n := range names {
+			r := s.mig[n]
+			rows = append(rows, []driver.Value{r.name, r.downSQL})
+		}
+		return &fakeRows{cols: []string{"name", "down_sql"}, rows: rows}, nil
+	case strings.HasPrefix(norm, "select name from"):
+		var rows [][]driver.Value
+		for _, r := range s.mig {
+			rows = append(rows, []driver.Value{r.name})
+		}
+		return &fakeRows{cols: []string{"name"}, rows: rows}, nil
+	default:
+		return nil, fmt.Errorf("fakedb: unsupported query: %s", norm)
+	}
+}
+
+type fakeResult struct{ rows int64 }
+
+func (r fakeResult) LastInsertId() (int64, error) { return 0, nil }
+func (r fakeResult) RowsAffected() (int64, error) { return r.rows, nil }
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+	pos  int
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.pos >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.pos])
+	r.pos++
+	return nil
+}
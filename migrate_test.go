@@ -0,0 +1,293 @@
+package sqlmig
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"sync"
+	"testing"
+	"testing/fstest"
+	"time"
+)
+
+func testSource(files map[string]string) (Source, fstest.MapFS) {
+	fsys := fstest.MapFS{}
+	for name, data := range files {
+		fsys[name] = &fstest.MapFile{Data: []byte(data)}
+	}
+	return Source{FS: fsys, Glob: "*.sql"}, fsys
+}
+
+func TestMigrateAppliesAndIsIdempotent(t *testing.T) {
+	db := newFakeDB(t)
+	src, _ := testSource(map[string]string{
+		"0001_a.sql": "-- +migrate Up\ncreate table a\n-- +migrate Down\ndrop table a\n",
+		"0002_b.sql": "-- +migrate Up\ncreate table b\n-- +migrate Down\ndrop table b\n",
+	})
+	if err := src.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+	statuses, err := src.Status(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Errorf("Status(%s).Applied = false, want true", s.Name)
+		}
+	}
+
+	// Running Migrate again must be a no-op: no PlanError, no duplicate
+	// insert (which the fake store would reject as a UNIQUE violation).
+	if err := src.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("second Migrate: %v", err)
+	}
+}
+
+func TestMigrateDetectsUnknownAndChecksumMismatch(t *testing.T) {
+	db := newFakeDB(t)
+	src, fsys := testSource(map[string]string{
+		"0001_a.sql": "-- +migrate Up\ncreate table a\n-- +migrate Down\ndrop table a\n",
+	})
+	if err := src.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	// Editing an already-applied file's contents changes its checksum.
+	fsys["0001_a.sql"] = &fstest.MapFile{Data: []byte("-- +migrate Up\ncreate table a2\n-- +migrate Down\ndrop table a2\n")}
+	err := src.Migrate(context.Background(), db)
+	var planErr *PlanError
+	if !errors.As(err, &planErr) || planErr.Reason != "checksum mismatch" {
+		t.Fatalf("Migrate after edit = %v, want checksum mismatch PlanError", err)
+	}
+
+	// Removing an already-applied file entirely makes it unknown.
+	delete(fsys, "0001_a.sql")
+	err = src.Migrate(context.Background(), db)
+	if !errors.As(err, &planErr) || planErr.Reason != "unknown migration" {
+		t.Fatalf("Migrate after removal = %v, want unknown migration PlanError", err)
+	}
+
+	src.Config.IgnoreChecksums = true
+	src.Config.IgnoreUnknown = true
+	if err := src.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("Migrate with IgnoreChecksums/IgnoreUnknown: %v", err)
+	}
+}
+
+func TestMigratorRollback(t *testing.T) {
+	db := newFakeDB(t)
+	src, _ := testSource(map[string]string{
+		"0001_a.sql": "-- +migrate Up\ncreate table a\n-- +migrate Down\ndrop table a\n",
+		"0002_b.sql": "-- +migrate Up\ncreate table b\n-- +migrate Down\ndrop table b\n",
+	})
+	if err := src.Migrate(context.Background(), db); err != nil {
+		t.Fatalf("Migrate: %v", err)
+	}
+
+	m := Migrator{Source: src}
+	if err := m.Rollback(context.Background(), db, 1); err != nil {
+		t.Fatalf("Rollback: %v", err)
+	}
+	statuses, err := src.Status(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	applied := map[string]bool{}
+	for _, s := range statuses {
+		applied[s.Name] = s.Applied
+	}
+	if applied["0002_b.sql"] {
+		t.Errorf("0002_b.sql still applied after Rollback(1)")
+	}
+	if !applied["0001_a.sql"] {
+		t.Errorf("0001_a.sql no longer applied after Rollback(1)")
+	}
+
+	if err := m.Redo(context.Background(), db); err != nil {
+		t.Fatalf("Redo: %v", err)
+	}
+	statuses, err = src.Status(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Status after Redo: %v", err)
+	}
+	for _, s := range statuses {
+		if !s.Applied {
+			t.Errorf("Status(%s).Applied = false after Redo, want true", s.Name)
+		}
+	}
+
+	if err := m.RollbackTo(context.Background(), db, "0001_a.sql"); err != nil {
+		t.Fatalf("RollbackTo: %v", err)
+	}
+	statuses, err = src.Status(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Status after RollbackTo: %v", err)
+	}
+	for _, s := range statuses {
+		if s.Applied {
+			t.Errorf("Status(%s).Applied = true after RollbackTo(0001_a.sql), want false", s.Name)
+		}
+	}
+}
+
+func TestPlanAndDryRun(t *testing.T) {
+	db := newFakeDB(t)
+	src, _ := testSource(map[string]string{
+		"0001_a.sql": "-- +migrate Up\ncreate table a\n-- +migrate Down\ndrop table a\n",
+		"0002_b.sql": "-- +migrate notransaction\n-- +migrate Up\ncreate table b\n-- +migrate Down\ndrop table b\n",
+	})
+	planned, err := src.Plan(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Plan: %v", err)
+	}
+	if len(planned) != 2 {
+		t.Fatalf("Plan returned %d migrations, want 2", len(planned))
+	}
+	if planned[0].NoTransaction {
+		t.Errorf("Plan[0].NoTransaction = true, want false")
+	}
+	if !planned[1].NoTransaction {
+		t.Errorf("Plan[1].NoTransaction = false, want true")
+	}
+
+	results, err := src.DryRun(context.Background(), db)
+	if err != nil {
+		t.Fatalf("DryRun: %v", err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("DryRun returned %d results, want 2", len(results))
+	}
+	if results[0].Err != nil {
+		t.Errorf("DryRun[0].Err = %v, want nil", results[0].Err)
+	}
+	if !results[1].Skipped {
+		t.Errorf("DryRun[1].Skipped = false, want true for a notransaction migration")
+	}
+
+	// DryRun must not have applied anything for real.
+	statuses, err := src.Status(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Status: %v", err)
+	}
+	for _, s := range statuses {
+		if s.Applied {
+			t.Errorf("Status(%s).Applied = true after DryRun, want false", s.Name)
+		}
+	}
+}
+
+// TestConcurrentMigrateSerializes drives two goroutines through Migrate
+// against the same database at once. Without a working advisory lock, both
+// would race past the "already applied?" check and both attempt the insert,
+// one of them failing with the fake store's UNIQUE constraint error.
+func TestConcurrentMigrateSerializes(t *testing.T) {
+	db := newFakeDB(t)
+	db.SetMaxOpenConns(4)
+	src, _ := testSource(map[string]string{
+		"0001_a.sql": "-- +migrate Up\ncreate table a\n-- +migrate Down\ndrop table a\n",
+	})
+
+	var wg sync.WaitGroup
+	errs := make([]error, 2)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = src.Migrate(context.Background(), db)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range errs {
+		if err != nil {
+			t.Errorf("Migrate[%d] = %v, want nil", i, err)
+		}
+	}
+}
+
+// testConnDialect wraps SQLite but replaces its lock with fake_conn_lock/
+// fake_conn_unlock, which fakeConn only honors for the connection that
+// acquired it -- modeling how Postgres/MySQL/MSSQL's session-scoped
+// advisory locks behave, so it can regression-test Migrate's connection
+// pinning independently of SQLite's own lease-based lock.
+type testConnDialect struct{ SQLite }
+
+func (testConnDialect) AdvisoryLock(ctx context.Context, db DB, name string, timeout time.Duration) (func(context.Context) error, error) {
+	if _, err := db.ExecContext(ctx, `select fake_conn_lock()`); err != nil {
+		return nil, err
+	}
+	return func(ctx context.Context) error {
+		_, err := db.ExecContext(ctx, `select fake_conn_unlock()`)
+		return err
+	}, nil
+}
+
+func TestConcurrentMigrateSerializesWithConnectionScopedLock(t *testing.T) {
+	db := newFakeDB(t)
+	db.SetMaxOpenConns(4)
+	// Force every call that returns a connection to the pool to have it
+	// closed rather than reused, so a single Migrate call's lock, migration
+	// work, and unlock are spread across distinct underlying connections
+	// unless something pins them together. Without the pinning fix in
+	// Source.Migrate, that makes this test fail deterministically instead of
+	// only on rare scheduling luck.
+	db.SetMaxIdleConns(0)
+	src, _ := testSource(map[string]string{
+		"0001_a.sql": "-- +migrate Up\ncreate table a\n-- +migrate Down\ndrop table a\n",
+	})
+	src.Dialect = testConnDialect{}
+
+	done := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			done <- src.Migrate(context.Background(), db)
+		}()
+	}
+	// Without connection pinning, a lock leaked on a connection Migrate no
+	// longer holds can block the other goroutine's acquire forever, so this
+	// waits with a bound instead of risking an indefinite hang.
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("Migrate: %v", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Migrate did not return within 5s; the advisory lock likely leaked on an unpinned connection")
+		}
+	}
+}
+
+// TestSQLiteAdvisoryLockReclaimsStaleLock simulates a process that crashed
+// while holding the lock: a lock row stuck at locked=1 with a locked_at
+// older than lockLease. A fresh AdvisoryLock call must steal it rather than
+// block forever. lockLease/lockHeartbeat are shrunk for the test so it
+// doesn't have to wait out the real 30s lease.
+func TestSQLiteAdvisoryLockReclaimsStaleLock(t *testing.T) {
+	origLease, origHeartbeat := lockLease, lockHeartbeat
+	lockLease = 50 * time.Millisecond
+	lockHeartbeat = lockLease / 5
+	t.Cleanup(func() { lockLease, lockHeartbeat = origLease, origHeartbeat })
+
+	db := newFakeDB(t)
+	ctx := context.Background()
+
+	if _, err := db.ExecContext(ctx, `create table if not exists db_migrations_lock (name text primary key, locked int not null default 0, locked_at timestamp)`); err != nil {
+		t.Fatalf("seed create: %v", err)
+	}
+	stale := time.Now().UTC().Add(-2 * lockLease)
+	if _, err := db.ExecContext(ctx, `insert into db_migrations_lock (name, locked, locked_at) values (?, 1, ?)`, "test-lock", stale); err != nil {
+		t.Fatalf("seed insert: %v", err)
+	}
+
+	unlock, err := SQLite{}.AdvisoryLock(ctx, db, "test-lock", 2*time.Second)
+	if err != nil {
+		t.Fatalf("AdvisoryLock did not reclaim a stale lock: %v", err)
+	}
+	if err := unlock(ctx); err != nil {
+		t.Fatalf("unlock: %v", err)
+	}
+}
+
+var _ DB = (*sql.DB)(nil)
+var _ DB = (*sql.Conn)(nil)
@@ -0,0 +1,25 @@
+package sqlmig
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// newMigrationSkeleton is the contents written for a freshly scaffolded
+// migration file.
+const newMigrationSkeleton = "-- +migrate Up\n\n-- +migrate Down\n"
+
+// NewMigration writes a timestamped skeleton migration file into dir and
+// returns its path, mirroring `sql-migrate new`. The timestamp prefix keeps
+// files in application order when sorted by name, matching how Migrate
+// orders the files it globs from Source.FS.
+func (s Source) NewMigration(name string, dir string) (string, error) {
+	filename := fmt.Sprintf("%s-%s.sql", time.Now().UTC().Format("20060102T150405"), name)
+	path := filepath.Join(dir, filename)
+	if err := os.WriteFile(path, []byte(newMigrationSkeleton), 0o644); err != nil {
+		return "", fmt.Errorf("sqlmig: error writing migration: %q: %w", path, err)
+	}
+	return path, nil
+}
@@ -3,24 +3,199 @@ package sqlmig
 
 import (
 	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io/fs"
 	"slices"
+	"strings"
+	"time"
+
+	"github.com/daaku/sqlmig/sqlparse"
 )
 
 // Source defines a FS and correlated Glob to provide a source of migrations.
 type Source struct {
 	FS   fs.FS
 	Glob string
+
+	// Config customizes the safety checks Migrate runs and the bookkeeping
+	// table it uses. The zero value applies all checks against the default
+	// table name.
+	Config Config
+
+	// Dialect routes the generated SQL to the target database. It defaults
+	// to SQLite, matching sqlmig's original SQLite-only behavior.
+	Dialect Dialect
+
+	// LockTimeout bounds how long Migrate waits to acquire the
+	// cross-process lock it takes before applying migrations. Zero means
+	// wait indefinitely.
+	LockTimeout time.Duration
+}
+
+// lockName identifies sqlmig's cross-process advisory lock. It's constant
+// rather than derived from TableName so that, by default, all Sources
+// against the same database serialize against each other.
+const lockName = "sqlmig"
+
+// ErrLockTimeout is returned by Migrate when LockTimeout elapses before the
+// cross-process lock could be acquired.
+var ErrLockTimeout = errors.New("sqlmig: timed out acquiring lock")
+
+// dialect returns the Dialect to use, defaulting to SQLite.
+func (s Source) dialect() Dialect {
+	if s.Dialect != nil {
+		return s.Dialect
+	}
+	return SQLite{}
+}
+
+// Config customizes Source's safety checks and bookkeeping table.
+type Config struct {
+	// IgnoreUnknown skips the error Migrate otherwise returns when the
+	// migrations table contains a name not present in Source, for example
+	// because it was removed from the source tree after being applied.
+	IgnoreUnknown bool
+	// IgnoreChecksums skips the error Migrate otherwise returns when an
+	// applied migration's file contents no longer match the checksum
+	// recorded when it was applied.
+	IgnoreChecksums bool
+	// TableName overrides the default "db_migrations" bookkeeping table.
+	TableName string
+	// DisableCreateTable skips the create-table-if-not-exists step, for
+	// users who provision the bookkeeping table themselves.
+	DisableCreateTable bool
 }
 
 // DB must be satisfied for executing migrations.
 type DB interface {
 	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
 	BeginTx(ctx context.Context, opts *sql.TxOptions) (*sql.Tx, error)
 }
 
+const defaultMigrationsTable = "db_migrations"
+
+// tableName returns the bookkeeping table to use, honoring Config.TableName.
+func (s Source) tableName() string {
+	if s.Config.TableName != "" {
+		return s.Config.TableName
+	}
+	return defaultMigrationsTable
+}
+
+// ensureColumn adds column to table if it isn't already there, so the
+// migration bookkeeping schema can grow without breaking users already on an
+// older version of it. table is the bare, unquoted table name; d.HasColumn
+// and d.AddColumnSQL are responsible for quoting it as needed.
+func ensureColumn(ctx context.Context, db DB, d Dialect, table, column, ddl string) error {
+	has, err := d.HasColumn(ctx, db, table, column)
+	if err != nil {
+		return fmt.Errorf("sqlmig: error checking for column: %q: %w", column, err)
+	}
+	if has {
+		return nil
+	}
+	if _, err := db.ExecContext(ctx, d.AddColumnSQL(table, column, ddl)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// checksum returns the hex-encoded SHA-256 of data.
+func checksum(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// placeholders joins n of d's positional placeholders, 1-indexed, for use in
+// a "values (...)" or similarly shaped clause.
+func placeholders(d Dialect, n int) string {
+	ph := make([]string, n)
+	for i := range ph {
+		ph[i] = d.Placeholder(i + 1)
+	}
+	return strings.Join(ph, ", ")
+}
+
+// PlanError is returned by Source.Migrate when the bookkeeping table's
+// history doesn't agree with Source, and Config hasn't opted out of the
+// corresponding check.
+type PlanError struct {
+	// Name is the migration the error is about.
+	Name string
+	// Reason is "unknown migration" or "checksum mismatch".
+	Reason string
+}
+
+func (e *PlanError) Error() string {
+	return fmt.Sprintf("sqlmig: %s: %q", e.Reason, e.Name)
+}
+
+// checkPlan compares the applied rows in the bookkeeping table against
+// files, returning a *PlanError for the first disagreement Config hasn't
+// opted out of. Checksums for pre-existing rows, from before the checksum
+// column existed, are backfilled lazily as they're encountered.
+func (s Source) checkPlan(ctx context.Context, db DB, files []string) error {
+	known := make(map[string]bool, len(files))
+	for _, f := range files {
+		known[f] = true
+	}
+	d := s.dialect()
+	table := d.QuoteIdent(s.tableName())
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`select name, checksum from %s`, table))
+	if err != nil {
+		return fmt.Errorf("sqlmig: error listing applied migrations: %w", err)
+	}
+	defer rows.Close()
+	type appliedRow struct {
+		name     string
+		checksum string
+	}
+	var applied []appliedRow
+	for rows.Next() {
+		var r appliedRow
+		var sum sql.NullString
+		if err := rows.Scan(&r.name, &sum); err != nil {
+			return fmt.Errorf("sqlmig: error reading applied migration: %w", err)
+		}
+		r.checksum = sum.String
+		applied = append(applied, r)
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("sqlmig: error reading applied migrations: %w", err)
+	}
+
+	for _, r := range applied {
+		if !known[r.name] {
+			if s.Config.IgnoreUnknown {
+				continue
+			}
+			return &PlanError{Name: r.name, Reason: "unknown migration"}
+		}
+		data, err := fs.ReadFile(s.FS, r.name)
+		if err != nil {
+			return fmt.Errorf("sqlmig: error reading migration: %q: %w", r.name, err)
+		}
+		sum := checksum(data)
+		if r.checksum == "" {
+			updateSQL := fmt.Sprintf(`update %s set checksum = %s where name = %s`, table, d.Placeholder(1), d.Placeholder(2))
+			if _, err := db.ExecContext(ctx, updateSQL, sum, r.name); err != nil {
+				return fmt.Errorf("sqlmig: error backfilling checksum: %q: %w", r.name, err)
+			}
+			continue
+		}
+		if r.checksum != sum && !s.Config.IgnoreChecksums {
+			return &PlanError{Name: r.name, Reason: "checksum mismatch"}
+		}
+	}
+	return nil
+}
+
 // Migrate runs the migrations on the target DB.
 func (s Source) Migrate(ctx context.Context, db DB) error {
 	files, err := fs.Glob(s.FS, s.Glob)
@@ -28,38 +203,94 @@ func (s Source) Migrate(ctx context.Context, db DB) error {
 		return fmt.Errorf("sqlmig: error globbing: %q: %w", s.Glob, err)
 	}
 	slices.Sort(files)
-	const migrationSchemaSQL = `
-	create table if not exists db_migrations (
-		name text primary key
-	)`
-	if _, err := db.ExecContext(ctx, migrationSchemaSQL); err != nil {
-		return fmt.Errorf("sqlmig: error creating db_migrations table: %w", err)
+
+	d := s.dialect()
+
+	// Postgres/MySQL/MSSQL's advisory locks are scoped to the connection
+	// that takes them, so db must be pinned to one connection for the lock,
+	// the migration work it guards, and the eventual unlock; otherwise
+	// ExecContext's per-call connection from the pool would acquire and
+	// release the lock on connections Migrate never actually uses.
+	if pooler, ok := db.(interface {
+		Conn(ctx context.Context) (*sql.Conn, error)
+	}); ok {
+		conn, err := pooler.Conn(ctx)
+		if err != nil {
+			return fmt.Errorf("sqlmig: error acquiring connection: %w", err)
+		}
+		defer conn.Close()
+		db = conn
+	}
+
+	unlock, err := d.AdvisoryLock(ctx, db, lockName, s.LockTimeout)
+	if err != nil {
+		if errors.Is(err, ErrLockTimeout) {
+			return err
+		}
+		return fmt.Errorf("sqlmig: error acquiring lock: %w", err)
+	}
+	defer unlock(ctx)
+
+	rawTable := s.tableName()
+	table := d.QuoteIdent(rawTable)
+	if !s.Config.DisableCreateTable {
+		if _, err := db.ExecContext(ctx, d.CreateTableSQL(rawTable)); err != nil {
+			return fmt.Errorf("sqlmig: error creating %s table: %w", table, err)
+		}
+	}
+	if err := ensureColumn(ctx, db, d, rawTable, "applied_at", d.ColumnType("timestamp")); err != nil {
+		return fmt.Errorf("sqlmig: error adding applied_at column: %w", err)
+	}
+	if err := ensureColumn(ctx, db, d, rawTable, "down_sql", d.ColumnType("text")); err != nil {
+		return fmt.Errorf("sqlmig: error adding down_sql column: %w", err)
+	}
+	if err := ensureColumn(ctx, db, d, rawTable, "checksum", d.ColumnType("text")); err != nil {
+		return fmt.Errorf("sqlmig: error adding checksum column: %w", err)
 	}
+
+	if err := s.checkPlan(ctx, db, files); err != nil {
+		return err
+	}
+
+	alreadyDoneSQL := fmt.Sprintf(`select count(*) from %s where name = %s`, table, d.Placeholder(1))
+	insertSQL := fmt.Sprintf(`insert into %s (name, applied_at, down_sql, checksum) values (%s)`, table, placeholders(d, 4))
 	for _, filename := range files {
 		data, err := fs.ReadFile(s.FS, filename)
 		if err != nil {
 			return fmt.Errorf("sqlmig: error reading migration: %q: %w", filename, err)
 		}
-		tx, err := db.BeginTx(ctx, nil)
+		mig, err := sqlparse.Parse(data)
 		if err != nil {
-			return fmt.Errorf("sqlmig: error starting tx: %w", err)
+			return fmt.Errorf("sqlmig: error parsing migration: %q: %w", filename, err)
 		}
-		defer tx.Rollback()
 
-		const alreadyDoneSQL = `select count(*) from db_migrations where name = ?`
 		var alreadyDone int
-		if err := tx.QueryRowContext(ctx, alreadyDoneSQL, filename).Scan(&alreadyDone); err != nil {
+		if err := db.QueryRowContext(ctx, alreadyDoneSQL, filename).Scan(&alreadyDone); err != nil {
 			return fmt.Errorf("sqlmig: error checking migration status: %q: %w", filename, err)
 		}
 		if alreadyDone == 1 {
-			tx.Rollback()
 			continue
 		}
-		if _, err := tx.ExecContext(ctx, `insert into db_migrations values (?)`, filename); err != nil {
+
+		if isNoTransaction(filename, mig) {
+			if err := s.migrateNoTransaction(ctx, db, insertSQL, filename, data, mig); err != nil {
+				return err
+			}
+			continue
+		}
+
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("sqlmig: error starting tx: %w", err)
+		}
+		defer tx.Rollback()
+		if _, err := tx.ExecContext(ctx, insertSQL, filename, time.Now().UTC(), strings.Join(mig.Down, ";\n"), checksum(data)); err != nil {
 			return fmt.Errorf("sqlmig: error updating migration status: %q: %w", filename, err)
 		}
-		if _, err := tx.ExecContext(ctx, string(data)); err != nil {
-			return fmt.Errorf("sqlmig: error executing migration: %q: %w", filename, err)
+		for _, stmt := range mig.Up {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("sqlmig: error executing migration: %q: %w", filename, err)
+			}
 		}
 		if err := tx.Commit(); err != nil {
 			return fmt.Errorf("sqlmig: error commiting migration: %q: %w", filename, err)
@@ -67,3 +298,161 @@ func (s Source) Migrate(ctx context.Context, db DB) error {
 	}
 	return nil
 }
+
+// isNoTransaction reports whether filename's migration should run directly
+// against db, outside of a transaction, either because it's marked `--
+// +migrate notransaction` or named "*.notx.sql".
+func isNoTransaction(filename string, mig *sqlparse.Migration) bool {
+	return mig.NoTransaction || strings.HasSuffix(filename, ".notx.sql")
+}
+
+// migrateNoTransaction applies a migration marked `-- +migrate notransaction`
+// (or named "*.notx.sql") directly on db, outside of any transaction, for
+// statements a driver can't run inside one (e.g. CREATE INDEX CONCURRENTLY
+// on Postgres). The applied row is recorded in a separate short transaction
+// afterwards, once every statement has succeeded.
+func (s Source) migrateNoTransaction(ctx context.Context, db DB, insertSQL, filename string, data []byte, mig *sqlparse.Migration) error {
+	for _, stmt := range mig.Up {
+		if _, err := db.ExecContext(ctx, stmt); err != nil {
+			return fmt.Errorf("sqlmig: error executing notransaction migration: %q: the database may be partially migrated and requires manual intervention: %w", filename, err)
+		}
+	}
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlmig: error starting tx: %w", err)
+	}
+	if _, err := tx.ExecContext(ctx, insertSQL, filename, time.Now().UTC(), strings.Join(mig.Down, ";\n"), checksum(data)); err != nil {
+		tx.Rollback()
+		return fmt.Errorf("sqlmig: error updating migration status: %q: %w", filename, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("sqlmig: error commiting migration status: %q: %w", filename, err)
+	}
+	return nil
+}
+
+// Migrator wraps a Source to add reversible migrations on top of its
+// forward-only Migrate: Rollback, RollbackTo, and Redo. Down statements come
+// from the migration file itself when it's still present in Source.FS, or
+// from the down_sql column recorded at apply time otherwise.
+type Migrator struct {
+	Source Source
+}
+
+// appliedMigration is a row read back from the migrations table.
+type appliedMigration struct {
+	name    string
+	downSQL string
+}
+
+// appliedDesc returns applied migrations ordered most-recently-applied
+// first, relying on the timestamp-prefixed naming convention so that name
+// order matches application order.
+func (m Migrator) appliedDesc(ctx context.Context, db DB) ([]appliedMigration, error) {
+	table := m.Source.dialect().QuoteIdent(m.Source.tableName())
+	query := fmt.Sprintf(`select name, down_sql from %s order by name desc`, table)
+	rows, err := db.QueryContext(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("sqlmig: error listing applied migrations: %w", err)
+	}
+	defer rows.Close()
+	var applied []appliedMigration
+	for rows.Next() {
+		var a appliedMigration
+		var downSQL sql.NullString
+		if err := rows.Scan(&a.name, &downSQL); err != nil {
+			return nil, fmt.Errorf("sqlmig: error reading applied migration: %w", err)
+		}
+		a.downSQL = downSQL.String
+		applied = append(applied, a)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlmig: error reading applied migrations: %w", err)
+	}
+	return applied, nil
+}
+
+// downStatements resolves the Down statements for an applied migration,
+// preferring the on-disk file (in case it was edited since being applied)
+// and falling back to what was recorded in the migrations table when the
+// file is gone.
+func (m Migrator) downStatements(a appliedMigration) ([]string, error) {
+	data, err := fs.ReadFile(m.Source.FS, a.name)
+	if err != nil {
+		if a.downSQL == "" {
+			return nil, nil
+		}
+		return strings.Split(a.downSQL, ";\n"), nil
+	}
+	mig, err := sqlparse.Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("sqlmig: error parsing migration: %q: %w", a.name, err)
+	}
+	return mig.Down, nil
+}
+
+// rollback reverses applied migrations, most recent first, until n of them
+// have been reversed or, if n is negative, until stopAfter has itself been
+// reversed.
+func (m Migrator) rollback(ctx context.Context, db DB, n int, stopAfter string) error {
+	applied, err := m.appliedDesc(ctx, db)
+	if err != nil {
+		return err
+	}
+	d := m.Source.dialect()
+	deleteSQL := fmt.Sprintf(`delete from %s where name = %s`, d.QuoteIdent(m.Source.tableName()), d.Placeholder(1))
+	reversed := 0
+	for _, a := range applied {
+		if n >= 0 && reversed >= n {
+			break
+		}
+		down, err := m.downStatements(a)
+		if err != nil {
+			return err
+		}
+		if len(down) == 0 {
+			return fmt.Errorf("sqlmig: no down migration recorded for %q", a.name)
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return fmt.Errorf("sqlmig: error starting tx: %w", err)
+		}
+		defer tx.Rollback()
+		for _, stmt := range down {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				return fmt.Errorf("sqlmig: error executing down migration: %q: %w", a.name, err)
+			}
+		}
+		if _, err := tx.ExecContext(ctx, deleteSQL, a.name); err != nil {
+			return fmt.Errorf("sqlmig: error updating migration status: %q: %w", a.name, err)
+		}
+		if err := tx.Commit(); err != nil {
+			return fmt.Errorf("sqlmig: error commiting rollback: %q: %w", a.name, err)
+		}
+		reversed++
+		if stopAfter != "" && a.name == stopAfter {
+			break
+		}
+	}
+	return nil
+}
+
+// Rollback reverses the last n applied migrations, most recent first.
+func (m Migrator) Rollback(ctx context.Context, db DB, n int) error {
+	return m.rollback(ctx, db, n, "")
+}
+
+// RollbackTo reverses applied migrations, most recent first, stopping once
+// name has itself been reversed.
+func (m Migrator) RollbackTo(ctx context.Context, db DB, name string) error {
+	return m.rollback(ctx, db, -1, name)
+}
+
+// Redo reverses the most recently applied migration and reapplies it by
+// running Source.Migrate, which also applies any other pending migrations.
+func (m Migrator) Redo(ctx context.Context, db DB) error {
+	if err := m.Rollback(ctx, db, 1); err != nil {
+		return err
+	}
+	return m.Source.Migrate(ctx, db)
+}
@@ -0,0 +1,127 @@
+// Package sqlparse splits a sqlmig migration file into its Up and Down
+// statements.
+package sqlparse
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Migration is the result of parsing a migration file into its Up and Down
+// sections.
+type Migration struct {
+	// Up is the ordered list of statements to apply.
+	Up []string
+	// Down is the ordered list of statements that reverse Up.
+	Down []string
+	// NoTransaction is true when the file contained a
+	// `-- +migrate notransaction` directive, meaning its statements cannot
+	// run inside a transaction.
+	NoTransaction bool
+}
+
+const directivePrefix = "-- +migrate"
+
+// Parse splits data into Up and Down statements, delimited by
+// `-- +migrate Up` and `-- +migrate Down` marker lines. Statements are split
+// on `;` outside of single- and double-quoted strings and `$$...$$`
+// dollar-quoted blocks. A `-- +migrate StatementBegin` / `StatementEnd` pair
+// keeps everything between them as a single statement regardless of
+// embedded `;`, for statements a driver can't otherwise split.
+func Parse(data []byte) (*Migration, error) {
+	m := &Migration{}
+	var (
+		section     string // "", "Up", or "Down"
+		buf         strings.Builder
+		inStmtBlock bool
+		inSingle    bool
+		inDouble    bool
+		inDollar    bool
+	)
+
+	flush := func() {
+		stmt := strings.TrimSpace(buf.String())
+		buf.Reset()
+		if stmt == "" {
+			return
+		}
+		switch section {
+		case "Up":
+			m.Up = append(m.Up, stmt)
+		case "Down":
+			m.Down = append(m.Down, stmt)
+		}
+	}
+
+	for _, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimSpace(line)
+		if !inSingle && !inDouble && !inDollar && strings.HasPrefix(trimmed, directivePrefix) {
+			switch directive := strings.TrimSpace(strings.TrimPrefix(trimmed, directivePrefix)); directive {
+			case "Up":
+				flush()
+				section = "Up"
+			case "Down":
+				flush()
+				section = "Down"
+			case "notransaction":
+				m.NoTransaction = true
+			case "StatementBegin":
+				flush()
+				inStmtBlock = true
+			case "StatementEnd":
+				flush()
+				inStmtBlock = false
+			default:
+				return nil, fmt.Errorf("sqlparse: unknown directive: %q", directive)
+			}
+			continue
+		}
+
+		if inStmtBlock {
+			buf.WriteString(line)
+			buf.WriteByte('\n')
+			continue
+		}
+
+		for i := 0; i < len(line); i++ {
+			c := line[i]
+			switch {
+			case inSingle:
+				if c == '\'' {
+					inSingle = false
+				}
+			case inDouble:
+				if c == '"' {
+					inDouble = false
+				}
+			case inDollar:
+				if c == '$' && i+1 < len(line) && line[i+1] == '$' {
+					inDollar = false
+					buf.WriteByte(c)
+					i++
+					c = '$'
+				}
+			case c == '\'':
+				inSingle = true
+			case c == '"':
+				inDouble = true
+			case c == '$' && i+1 < len(line) && line[i+1] == '$':
+				inDollar = true
+				buf.WriteByte(c)
+				i++
+				c = '$'
+			case c == ';':
+				flush()
+				continue
+			}
+			buf.WriteByte(c)
+		}
+		buf.WriteByte('\n')
+	}
+	flush()
+
+	if section == "" {
+		return nil, fmt.Errorf("sqlparse: missing %q marker", directivePrefix+" Up")
+	}
+	return m, nil
+}
@@ -0,0 +1,97 @@
+package sqlparse
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		name    string
+		data    string
+		want    *Migration
+		wantErr bool
+	}{
+		{
+			name: "basic up and down",
+			data: "-- +migrate Up\ncreate table foo (id int);\n-- +migrate Down\ndrop table foo;\n",
+			want: &Migration{
+				Up:   []string{"create table foo (id int)"},
+				Down: []string{"drop table foo"},
+			},
+		},
+		{
+			name: "multiple statements split on semicolon",
+			data: "-- +migrate Up\ncreate table foo (id int);\ninsert into foo values (1);\n-- +migrate Down\ndrop table foo;\n",
+			want: &Migration{
+				Up:   []string{"create table foo (id int)", "insert into foo values (1)"},
+				Down: []string{"drop table foo"},
+			},
+		},
+		{
+			name: "semicolon inside quoted string is not a split point",
+			data: "-- +migrate Up\ninsert into foo (name) values ('a;b');\n-- +migrate Down\ndelete from foo;\n",
+			want: &Migration{
+				Up:   []string{"insert into foo (name) values ('a;b')"},
+				Down: []string{"delete from foo"},
+			},
+		},
+		{
+			name: "dollar quoted block is not split on semicolon",
+			data: "-- +migrate Up\ncreate function f() returns int as $$\nbegin\nreturn 1;\nend;\n$$ language plpgsql;\n-- +migrate Down\ndrop function f();\n",
+			want: &Migration{
+				Up: []string{
+					"create function f() returns int as $$\nbegin\nreturn 1;\nend;\n$$ language plpgsql",
+				},
+				Down: []string{"drop function f()"},
+			},
+		},
+		{
+			name: "statement begin end keeps embedded semicolons together",
+			data: "-- +migrate Up\n-- +migrate StatementBegin\ncreate trigger t before insert on foo begin\nselect 1;\nselect 2;\nend;\n-- +migrate StatementEnd\n-- +migrate Down\ndrop trigger t;\n",
+			want: &Migration{
+				Up: []string{
+					"create trigger t before insert on foo begin\nselect 1;\nselect 2;\nend;",
+				},
+				Down: []string{"drop trigger t"},
+			},
+		},
+		{
+			name: "notransaction directive is recorded",
+			data: "-- +migrate Up\n-- +migrate notransaction\ncreate index concurrently idx on foo (id);\n-- +migrate Down\ndrop index idx;\n",
+			want: &Migration{
+				Up:            []string{"create index concurrently idx on foo (id)"},
+				Down:          []string{"drop index idx"},
+				NoTransaction: true,
+			},
+		},
+		{
+			name:    "unknown directive is an error",
+			data:    "-- +migrate Up\nselect 1;\n-- +migrate Sideways\n",
+			wantErr: true,
+		},
+		{
+			name:    "missing up marker is an error",
+			data:    "select 1;\n",
+			wantErr: true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := Parse([]byte(c.data))
+			if c.wantErr {
+				if err == nil {
+					t.Fatalf("Parse() error = nil, want error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Parse() error = %v, want nil", err)
+			}
+			if !reflect.DeepEqual(got, c.want) {
+				t.Fatalf("Parse() = %+v, want %+v", got, c.want)
+			}
+		})
+	}
+}
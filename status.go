@@ -0,0 +1,189 @@
+package sqlmig
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"io/fs"
+	"slices"
+	"time"
+
+	"github.com/daaku/sqlmig/sqlparse"
+)
+
+// MigrationStatus describes one migration's state relative to the
+// bookkeeping table, as returned by Status.
+type MigrationStatus struct {
+	// Name is the migration's file name, or the bookkeeping row's name for
+	// an Orphan with no corresponding file.
+	Name string
+	// Applied is true if Name has been applied.
+	Applied bool
+	// AppliedAt is when Name was applied, if Applied.
+	AppliedAt time.Time
+	// Orphan is true if Name has an applied row but no corresponding file
+	// in Source.
+	Orphan bool
+}
+
+// Status reports every file's applied state, plus any orphan rows in the
+// bookkeeping table that no longer correspond to a file in Source.
+func (s Source) Status(ctx context.Context, db DB) ([]MigrationStatus, error) {
+	files, err := fs.Glob(s.FS, s.Glob)
+	if err != nil {
+		return nil, fmt.Errorf("sqlmig: error globbing: %q: %w", s.Glob, err)
+	}
+	slices.Sort(files)
+
+	table := s.dialect().QuoteIdent(s.tableName())
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`select name, applied_at from %s`, table))
+	if err != nil {
+		return nil, fmt.Errorf("sqlmig: error listing applied migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := map[string]time.Time{}
+	for rows.Next() {
+		var name string
+		var appliedAt sql.NullTime
+		if err := rows.Scan(&name, &appliedAt); err != nil {
+			return nil, fmt.Errorf("sqlmig: error reading applied migration: %w", err)
+		}
+		applied[name] = appliedAt.Time
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlmig: error reading applied migrations: %w", err)
+	}
+
+	known := make(map[string]bool, len(files))
+	statuses := make([]MigrationStatus, 0, len(files))
+	for _, f := range files {
+		known[f] = true
+		at, ok := applied[f]
+		statuses = append(statuses, MigrationStatus{Name: f, Applied: ok, AppliedAt: at})
+	}
+	orphans := make([]string, 0)
+	for name := range applied {
+		if !known[name] {
+			orphans = append(orphans, name)
+		}
+	}
+	slices.Sort(orphans)
+	for _, name := range orphans {
+		statuses = append(statuses, MigrationStatus{Name: name, Applied: true, AppliedAt: applied[name], Orphan: true})
+	}
+	return statuses, nil
+}
+
+// PlannedMigration is a pending migration Plan would apply, in the order
+// Migrate would apply it.
+type PlannedMigration struct {
+	// Name is the migration's file name.
+	Name string
+	// Up is the ordered list of statements Migrate would execute.
+	Up []string
+	// Down is the ordered list of statements that reverse Up.
+	Down []string
+	// NoTransaction is true if Migrate would apply this migration directly
+	// against db, outside of a transaction.
+	NoTransaction bool
+}
+
+// Plan returns the pending migrations, in application order, without
+// applying them.
+func (s Source) Plan(ctx context.Context, db DB) ([]PlannedMigration, error) {
+	files, err := fs.Glob(s.FS, s.Glob)
+	if err != nil {
+		return nil, fmt.Errorf("sqlmig: error globbing: %q: %w", s.Glob, err)
+	}
+	slices.Sort(files)
+
+	table := s.dialect().QuoteIdent(s.tableName())
+	rows, err := db.QueryContext(ctx, fmt.Sprintf(`select name from %s`, table))
+	if err != nil {
+		return nil, fmt.Errorf("sqlmig: error listing applied migrations: %w", err)
+	}
+	defer rows.Close()
+	applied := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, fmt.Errorf("sqlmig: error reading applied migration: %w", err)
+		}
+		applied[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("sqlmig: error reading applied migrations: %w", err)
+	}
+
+	var planned []PlannedMigration
+	for _, f := range files {
+		if applied[f] {
+			continue
+		}
+		data, err := fs.ReadFile(s.FS, f)
+		if err != nil {
+			return nil, fmt.Errorf("sqlmig: error reading migration: %q: %w", f, err)
+		}
+		mig, err := sqlparse.Parse(data)
+		if err != nil {
+			return nil, fmt.Errorf("sqlmig: error parsing migration: %q: %w", f, err)
+		}
+		planned = append(planned, PlannedMigration{
+			Name:          f,
+			Up:            mig.Up,
+			Down:          mig.Down,
+			NoTransaction: isNoTransaction(f, mig),
+		})
+	}
+	return planned, nil
+}
+
+// DryRunResult reports whether a single pending migration applied cleanly
+// during a DryRun.
+type DryRunResult struct {
+	// Name is the migration's file name.
+	Name string
+	// Err is the error executing it hit, if any.
+	Err error
+	// Skipped is true for a notransaction migration, which DryRun can't
+	// exercise without either mutating the database for real or wrapping it
+	// in a transaction it was explicitly written to run without.
+	Skipped bool
+}
+
+// DryRun runs every pending migration inside a transaction that's always
+// rolled back, reporting per-migration success or failure without mutating
+// the database. Because each migration's transaction is rolled back before
+// the next one runs, a later migration that depends on an earlier one's
+// schema change will report failure even though Migrate would apply both
+// successfully. Notransaction migrations are reported as Skipped rather
+// than run, since Migrate applies them directly against db and DryRun has
+// no way to do that without either mutating the database or wrapping the
+// statements in the very transaction they opted out of.
+func (s Source) DryRun(ctx context.Context, db DB) ([]DryRunResult, error) {
+	planned, err := s.Plan(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+	results := make([]DryRunResult, 0, len(planned))
+	for _, p := range planned {
+		if p.NoTransaction {
+			results = append(results, DryRunResult{Name: p.Name, Skipped: true})
+			continue
+		}
+		tx, err := db.BeginTx(ctx, nil)
+		if err != nil {
+			return nil, fmt.Errorf("sqlmig: error starting tx: %w", err)
+		}
+		var stmtErr error
+		for _, stmt := range p.Up {
+			if _, err := tx.ExecContext(ctx, stmt); err != nil {
+				stmtErr = err
+				break
+			}
+		}
+		tx.Rollback()
+		results = append(results, DryRunResult{Name: p.Name, Err: stmtErr})
+	}
+	return results, nil
+}